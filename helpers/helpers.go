@@ -0,0 +1,33 @@
+// Package helpers provides the runtime support that generated Validate and DebugMap methods call
+// into: github.com/ecordell/optgen/helpers is imported by the code optgen generates, not by
+// optgen itself, so it lives in this module purely as that generated code's dependency.
+package helpers
+
+import "fmt"
+
+// ValidationError describes a single struct tag validation rule failed by a generated Validate
+// method, e.g. a `validate:"required"` field left at its zero value.
+type ValidationError struct {
+	Field string
+	Rule  string
+	Value any
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("field %s failed validation rule %q (value: %v)", e.Field, e.Rule, e.Value)
+}
+
+// DebugValue returns v for inclusion in a generated DebugMap, using v's default formatting when
+// format is true (the `debugmap:"visible-format"` case) or the raw value otherwise.
+func DebugValue(v any, format bool) any {
+	if format {
+		return fmt.Sprintf("%v", v)
+	}
+	return v
+}
+
+// SensitiveDebugValue redacts v for inclusion in a generated DebugMap, for fields tagged
+// `debugmap:"sensitive"`.
+func SensitiveDebugValue(v any) any {
+	return "***"
+}
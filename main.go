@@ -9,9 +9,9 @@ import (
 	"io"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -19,13 +19,12 @@ import (
 	"github.com/dave/jennifer/jen"
 	"github.com/fatih/structtag"
 	"golang.org/x/tools/go/packages"
+	"gopkg.in/yaml.v3"
 )
 
 type WriterProvider func() io.Writer
 
 // TODO: struct tags to know what to generate
-// TODO: recursive generation, i.e. WithMetadata(WithName())
-// TODO: optional flattening of recursive generation, i.e. WithMetadataName()
 // TODO: configurable field prefix
 // TODO: exported / unexported generation
 
@@ -48,11 +47,28 @@ func main() {
 		DefaultSensitiveNames,
 		"Substring matches of field names that should be considered sensitive",
 	)
+	protoFlag := fs.Bool(
+		"proto",
+		false,
+		"Treat the target structs as generated protobuf messages and generate proto-aware options",
+	)
+	configFlag := fs.String(
+		"config",
+		"",
+		"Path to an optgen.yml manifest; when set, positional args are ignored and every entry in the manifest is generated",
+	)
 
 	if err := fs.Parse(os.Args[1:]); err != nil {
 		log.Fatal(err.Error())
 	}
 
+	if *configFlag != "" {
+		if err := runManifest(*configFlag); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if len(fs.Args()) < 2 {
 		// TODO: usage
 		log.Fatal("must specify a package directory and a struct to provide options for")
@@ -60,10 +76,6 @@ func main() {
 
 	pkgName := fs.Arg(0)
 	structNames := fs.Args()[1:]
-	structFilter := make(map[string]struct{}, len(structNames))
-	for _, structName := range structNames {
-		structFilter[structName] = struct{}{}
-	}
 
 	var writer WriterProvider
 	if outputPathFlag != nil {
@@ -76,64 +88,137 @@ func main() {
 		}
 	}
 
-	packagePath, packageName := func() (string, string) {
-		cfg := &packages.Config{
-			Mode: packages.NeedTypes | packages.NeedTypesInfo,
-		}
-		pkgs, err := packages.Load(cfg, path.Dir(*outputPathFlag))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "load: %v\n", err)
-			os.Exit(1)
-		}
-		if packages.PrintErrors(pkgs) > 0 {
-			os.Exit(1)
-		}
-		return pkgs[0].Types.Path(), pkgs[0].Types.Name()
-	}()
-	if pkgNameFlag != nil && *pkgNameFlag != "" {
-		packageName = *pkgNameFlag
-	}
-
 	sensitiveNameMatches := make([]string, 0)
 	if sensitiveFieldNamesFlag != nil {
 		sensitiveNameMatches = strings.Split(*sensitiveFieldNamesFlag, ",")
 	}
 
-	err := func() error {
-		cfg := &packages.Config{
-			Mode: packages.NeedFiles | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedImports | packages.NeedSyntax,
-		}
-		pkgs, err := packages.Load(cfg, pkgName)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "load: %v\n", err)
-			os.Exit(1)
-		}
-		if packages.PrintErrors(pkgs) > 0 {
-			os.Exit(1)
-		}
+	count, err := generatePackage(pkgName, structNames, *outputPathFlag, *pkgNameFlag, sensitiveNameMatches, *protoFlag, GenerationOverrides{}, writer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Generated %d options\n", count)
+}
+
+// generatePackage loads pkgDir, finds structNames within it, and generates options for them,
+// writing the result via writer if non-nil or else next to each source file. It backs both the
+// single-invocation CLI path and each entry of an optgen.yml manifest.
+func generatePackage(pkgDir string, structNames []string, outputPath, packageNameOverride string, sensitiveNameMatches []string, proto bool, overrides GenerationOverrides, writer WriterProvider) (int, error) {
+	structFilter := make(map[string]struct{}, len(structNames))
+	for _, structName := range structNames {
+		structFilter[structName] = struct{}{}
+	}
 
-		count := 0
-		for _, pkg := range pkgs {
-			for _, f := range pkg.Syntax {
-				structs := findStructDefs(f, pkg.TypesInfo.Defs, structFilter)
+	cfg := &packages.Config{
+		Mode: packages.NeedFiles | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedImports | packages.NeedSyntax,
+	}
+	pkgs, err := packages.Load(cfg, pkgDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load: %v\n", err)
+		os.Exit(1)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		os.Exit(1)
+	}
+
+	packagePath, packageName := pkgs[0].Types.Path(), pkgs[0].Types.Name()
+	if packageNameOverride != "" {
+		packageName = packageNameOverride
+	}
+
+	count := 0
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Syntax {
+			if proto {
+				structs := findProtoStructDefs(f, pkg.TypesInfo.Defs, structFilter)
 				if len(structs) == 0 {
 					continue
 				}
-				fmt.Printf("Generating options for %s.%s...\n", packageName, strings.Join(structNames, ", "))
-				err = generateForFile(structs, packagePath, packageName, f.Name.Name, *outputPathFlag, sensitiveNameMatches, writer)
-				if err != nil {
-					return err
+				fmt.Printf("Generating proto options for %s.%s...\n", packageName, strings.Join(structNames, ", "))
+				if err := generateForProtoFile(structs, packagePath, packageName, f.Name.Name, outputPath, sensitiveNameMatches, writer, overrides); err != nil {
+					return count, err
 				}
 				count++
+				continue
 			}
+
+			structs := findStructDefs(f, pkg.TypesInfo.Defs, structFilter)
+			if len(structs) == 0 {
+				continue
+			}
+			fmt.Printf("Generating options for %s.%s...\n", packageName, strings.Join(structNames, ", "))
+			if err := generateForFile(structs, packagePath, packageName, f.Name.Name, outputPath, sensitiveNameMatches, writer, overrides); err != nil {
+				return count, err
+			}
+			count++
 		}
-		fmt.Printf("Generated %d options\n", count)
+	}
 
-		return nil
-	}()
+	return count, nil
+}
+
+// Manifest is the top-level shape of an optgen.yml config file: a checked-in, repeatable
+// generation plan that replaces a single CLI invocation per struct, modeled on gqlgen's config.
+type Manifest struct {
+	Entries []ManifestEntry `yaml:"entries"`
+}
+
+// ManifestEntry describes one generation pass: a package, the structs within it to generate
+// options for, where to write the result, and any per-field overrides.
+type ManifestEntry struct {
+	Package          string                              `yaml:"package"`
+	Structs          []string                            `yaml:"structs"`
+	Output           string                              `yaml:"output"`
+	SensitiveMatches []string                            `yaml:"sensitive_matches"`
+	Defaults         *bool                               `yaml:"defaults"`
+	Proto            bool                                `yaml:"proto"`
+	Fields           map[string]map[string]FieldOverride `yaml:"fields"`
+}
+
+// runManifest loads an optgen.yml manifest from configPath and generates options for every entry.
+func runManifest(configPath string) error {
+	data, err := os.ReadFile(configPath)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("reading config %s: %w", configPath, err)
+	}
+
+	var manifest Manifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("parsing config %s: %w", configPath, err)
+	}
+
+	total := 0
+	for _, entry := range manifest.Entries {
+		sensitiveNameMatches := entry.SensitiveMatches
+		if len(sensitiveNameMatches) == 0 {
+			sensitiveNameMatches = strings.Split(DefaultSensitiveNames, ",")
+		}
+
+		var writer WriterProvider
+		if entry.Output != "" {
+			output := entry.Output
+			writer = func() io.Writer {
+				w, err := os.OpenFile(output, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o600)
+				if err != nil {
+					log.Fatalf("couldn't open %s for writing", output)
+				}
+				return w
+			}
+		}
+
+		overrides := GenerationOverrides{
+			SkipDefaults:   entry.Defaults != nil && !*entry.Defaults,
+			FieldOverrides: entry.Fields,
+		}
+
+		count, err := generatePackage(entry.Package, entry.Structs, entry.Output, "", sensitiveNameMatches, entry.Proto, overrides, writer)
+		if err != nil {
+			return fmt.Errorf("manifest entry for package %s: %w", entry.Package, err)
+		}
+		total += count
 	}
+	fmt.Printf("Generated %d options across %d manifest entries\n", total, len(manifest.Entries))
+	return nil
 }
 
 func findStructDefs(file *ast.File, defs map[*ast.Ident]types.Object, names map[string]struct{}) []types.Object {
@@ -176,6 +261,42 @@ func findStructDefs(file *ast.File, defs map[*ast.Ident]types.Object, names map[
 	return objs
 }
 
+// isProtoMessage reports whether obj's type looks like a generated protobuf message. Detection is
+// method-set sniffing rather than an import check, mirroring the isStringer pattern used by
+// gopy-style generators: a type is a proto message if it (or its pointer) implements Reset(),
+// String(), and ProtoMessage().
+func isProtoMessage(obj types.Object) bool {
+	named, ok := obj.Type().(*types.Named)
+	if !ok {
+		return false
+	}
+	return hasMethod(named, "Reset") && hasMethod(named, "String") && hasMethod(named, "ProtoMessage")
+}
+
+func hasMethod(named *types.Named, name string) bool {
+	for _, t := range []types.Type{named, types.NewPointer(named)} {
+		mset := types.NewMethodSet(t)
+		for i := 0; i < mset.Len(); i++ {
+			if mset.At(i).Obj().Name() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findProtoStructDefs is findStructDefs narrowed to structs that look like generated protobuf messages.
+func findProtoStructDefs(file *ast.File, defs map[*ast.Ident]types.Object, names map[string]struct{}) []types.Object {
+	all := findStructDefs(file, defs, names)
+	proto := make([]types.Object, 0, len(all))
+	for _, obj := range all {
+		if isProtoMessage(obj) {
+			proto = append(proto, obj)
+		}
+	}
+	return proto
+}
+
 type Config struct {
 	ReceiverId     string
 	OptTypeName    string
@@ -183,9 +304,76 @@ type Config struct {
 	StructRef      []jen.Code
 	StructName     string
 	PkgPath        string
+	FieldOverrides map[string]FieldOverride
+
+	// TypeParamsDecl holds the enclosing struct's type parameters, declaration-form (e.g. `T any`),
+	// for use when declaring the generated Option type. Empty for non-generic structs.
+	TypeParamsDecl []jen.Code
+	// TypeArgs holds the enclosing struct's type parameters, reference-form (e.g. `T`), for use
+	// whenever the generated Option type is referenced rather than declared. Empty for non-generic
+	// structs.
+	TypeArgs []jen.Code
 }
 
-func generateForFile(objs []types.Object, pkgPath, pkgName, fileName, outpath string, sensitiveNameMatches []string, writer WriterProvider) error {
+// optTypeCode returns a reference to this Config's option function type, instantiated with the
+// enclosing struct's type parameters (if any) so generic structs emit e.g. FooOption[T].
+func (c Config) optTypeCode() jen.Code {
+	stmt := jen.Id(c.OptTypeName)
+	if len(c.TypeArgs) > 0 {
+		stmt = stmt.Types(c.TypeArgs...)
+	}
+	return stmt
+}
+
+// declareTypeParams appends this Config's `[T any]`-style type parameter declaration to a free
+// function's declaration statement, if the enclosing struct is generic. Methods must not call
+// this: Go carries a method's type parameters on its receiver, not on the method name itself.
+func (c Config) declareTypeParams(stmt *jen.Statement) *jen.Statement {
+	if len(c.TypeParamsDecl) > 0 {
+		return stmt.Types(c.TypeParamsDecl...)
+	}
+	return stmt
+}
+
+// instantiate appends this Config's bare type arguments (e.g. `[T]`, no constraints) to a
+// reference to a generic function or type, for use at call sites and in other generic references.
+func (c Config) instantiate(stmt *jen.Statement) *jen.Statement {
+	if len(c.TypeArgs) > 0 {
+		return stmt.Types(c.TypeArgs...)
+	}
+	return stmt
+}
+
+// FieldOverride is a per-field tweak to the default generation behavior for a single field,
+// sourced from an optgen.yml manifest entry.
+type FieldOverride struct {
+	Rename     string `yaml:"rename"`
+	OptionName string `yaml:"option_name"`
+	Skip       bool   `yaml:"skip"`
+	ForceSet   bool   `yaml:"force_set"`
+}
+
+// GenerationOverrides carries per-invocation tweaks to the default generation behavior, populated
+// from an optgen.yml manifest entry. The zero value reproduces the original CLI behavior.
+type GenerationOverrides struct {
+	SkipDefaults   bool
+	FieldOverrides map[string]map[string]FieldOverride // struct name -> field name -> override
+}
+
+// optionFieldName returns the name a field's generated With*/Set* functions should use, honoring
+// an `option_name` or `rename` override if one is present.
+func optionFieldName(f *types.Var, c Config) string {
+	ov := c.FieldOverrides[f.Name()]
+	if ov.OptionName != "" {
+		return ov.OptionName
+	}
+	if ov.Rename != "" {
+		return ov.Rename
+	}
+	return f.Name()
+}
+
+func generateForFile(objs []types.Object, pkgPath, pkgName, fileName, outpath string, sensitiveNameMatches []string, writer WriterProvider, overrides GenerationOverrides) error {
 	outdir, err := filepath.Abs(filepath.Dir(outpath))
 	if err != nil {
 		return err
@@ -207,6 +395,7 @@ func generateForFile(objs []types.Object, pkgPath, pkgName, fileName, outpath st
 			StructRef:      []jen.Code{jen.Id(def.Name())},
 			StructName:     def.Name(),
 			PkgPath:        pkgPath,
+			FieldOverrides: overrides.FieldOverrides[def.Name()],
 		}
 
 		// if output is not to the same package, qualify imports
@@ -216,14 +405,38 @@ func generateForFile(objs []types.Object, pkgPath, pkgName, fileName, outpath st
 			config.StructName = jen.Qual(structPkg, def.Name()).GoString()
 		}
 
+		// if the struct itself is generic, propagate its type parameters into the Option type
+		// declaration, the struct reference, and every With*/New*/etc. signature that references it
+		if named, ok := def.Type().(*types.Named); ok && named.TypeParams() != nil && named.TypeParams().Len() > 0 {
+			tparams := named.TypeParams()
+			for i := 0; i < tparams.Len(); i++ {
+				tp := tparams.At(i)
+				constraintRef := typeSpecForType(tp.Constraint(), config)
+				config.TypeParamsDecl = append(config.TypeParamsDecl, jen.Id(tp.Obj().Name()).Add(constraintRef...))
+				config.TypeArgs = append(config.TypeArgs, jen.Id(tp.Obj().Name()))
+			}
+			config.StructRef[0] = config.StructRef[0].(*jen.Statement).Types(config.TypeArgs...)
+		}
+
 		// generate the Option type
 		writeOptionType(buf, config)
 
 		// generate NewXWithOptions
 		writeNewXWithOptions(buf, config)
 
-		// generate NewXWithOptionsAndDefaults
-		writeNewXWithOptionsAndDefaults(buf, config)
+		// generate NewXWithOptionsAndDefaults, unless the manifest entry opted out
+		if !overrides.SkipDefaults {
+			writeNewXWithOptionsAndDefaults(buf, config)
+		}
+
+		// generate Validate and the Must* constructors, if the struct opts into validation
+		if hasValidationTags(st) {
+			writeValidate(buf, st, config)
+			writeMustNewXWithOptions(buf, config)
+			if !overrides.SkipDefaults {
+				writeMustNewXWithOptionsAndDefaults(buf, config)
+			}
+		}
 
 		// generate ToOption
 		writeToOption(buf, st, config)
@@ -231,6 +444,10 @@ func generateForFile(objs []types.Object, pkgPath, pkgName, fileName, outpath st
 		// generate DebugMap
 		writeDebugMap(buf, st, config, sensitiveNameMatches)
 
+		// generate LoadFromEnv/LoadFromMap
+		writeLoadFromEnv(buf, st, config, sensitiveNameMatches)
+		writeLoadFromMap(buf, st, config, sensitiveNameMatches)
+
 		// generate WithOptions
 		writeXWithOptions(buf, config)
 		writeWithOptions(buf, config)
@@ -251,15 +468,81 @@ func generateForFile(objs []types.Object, pkgPath, pkgName, fileName, outpath st
 	return buf.Render(w)
 }
 
+// generateForProtoFile is generateForFile's counterpart for generated protobuf messages: it skips
+// XXX_ bookkeeping fields, generates oneof-case setters instead of plain field setters for oneof
+// wrapper fields, and additionally emits FromProto/ApplyToProto conversion helpers.
+func generateForProtoFile(objs []types.Object, pkgPath, pkgName, fileName, outpath string, sensitiveNameMatches []string, writer WriterProvider, overrides GenerationOverrides) error {
+	outdir, err := filepath.Abs(filepath.Dir(outpath))
+	if err != nil {
+		return err
+	}
+
+	buf := jen.NewFilePathName(outpath, pkgName)
+	buf.PackageComment("Code generated by github.com/ecordell/optgen. DO NOT EDIT.")
+
+	for _, def := range objs {
+		st, ok := def.Type().Underlying().(*types.Struct)
+		if !ok {
+			return errors.New("type is not a struct")
+		}
+
+		config := Config{
+			ReceiverId:     strings.ToLower(string(def.Name()[0])),
+			OptTypeName:    fmt.Sprintf("%sOption", def.Name()),
+			TargetTypeName: strings.Title(def.Name()),
+			StructRef:      []jen.Code{jen.Id(def.Name())},
+			StructName:     def.Name(),
+			PkgPath:        pkgPath,
+			FieldOverrides: overrides.FieldOverrides[def.Name()],
+		}
+
+		// if output is not to the same package, qualify imports
+		structPkg := st.Field(0).Pkg().Path()
+		if pkgPath != structPkg {
+			config.StructRef = []jen.Code{jen.Qual(structPkg, def.Name())}
+			config.StructName = jen.Qual(structPkg, def.Name()).GoString()
+		}
+
+		writeOptionType(buf, config)
+		writeNewXWithOptions(buf, config)
+		if !overrides.SkipDefaults {
+			writeNewXWithOptionsAndDefaults(buf, config)
+		}
+		writeToOption(buf, st, config)
+		writeDebugMap(buf, st, config, sensitiveNameMatches)
+		writeXWithOptions(buf, config)
+		writeWithOptions(buf, config)
+
+		writeAllProtoWithOptFuncs(buf, st, outdir, config)
+		writeFromProto(buf, st, config)
+		writeApplyToProto(buf, st, config)
+	}
+
+	w := writer()
+	if w == nil {
+		optFile := strings.Replace(fileName, ".go", "_opts.go", 1)
+		w, err = os.OpenFile(optFile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+	}
+
+	return buf.Render(w)
+}
+
 func writeOptionType(buf *jen.File, c Config) {
-	buf.Type().Id(c.OptTypeName).Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...))
+	stmt := buf.Type().Id(c.OptTypeName)
+	if len(c.TypeParamsDecl) > 0 {
+		stmt = stmt.Types(c.TypeParamsDecl...)
+	}
+	stmt.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...))
 }
 
 func writeNewXWithOptions(buf *jen.File, c Config) {
 	newFuncName := fmt.Sprintf("New%sWithOptions", c.TargetTypeName)
 	buf.Comment(fmt.Sprintf("%s creates a new %s with the passed in options set", newFuncName, c.StructName))
-	buf.Func().Id(newFuncName).Params(
-		jen.Id("opts").Op("...").Id(c.OptTypeName),
+	c.declareTypeParams(buf.Func().Id(newFuncName)).Params(
+		jen.Id("opts").Op("...").Add(c.optTypeCode()),
 	).Op("*").Add(c.StructRef...).BlockFunc(func(grp *jen.Group) {
 		grp.Id(c.ReceiverId).Op(":=").Op("&").Add(c.StructRef...).Block()
 		applyOptions(c.ReceiverId)(grp)
@@ -269,8 +552,8 @@ func writeNewXWithOptions(buf *jen.File, c Config) {
 func writeNewXWithOptionsAndDefaults(buf *jen.File, c Config) {
 	newFuncName := fmt.Sprintf("New%sWithOptionsAndDefaults", c.TargetTypeName)
 	buf.Comment(fmt.Sprintf("%s creates a new %s with the passed in options set starting from the defaults", newFuncName, c.StructName))
-	buf.Func().Id(newFuncName).Params(
-		jen.Id("opts").Op("...").Id(c.OptTypeName),
+	c.declareTypeParams(buf.Func().Id(newFuncName)).Params(
+		jen.Id("opts").Op("...").Add(c.optTypeCode()),
 	).Op("*").Add(c.StructRef...).BlockFunc(func(grp *jen.Group) {
 		grp.Id(c.ReceiverId).Op(":=").Op("&").Add(c.StructRef...).Block()
 		grp.Qual("github.com/creasty/defaults", "MustSet").Call(jen.Id(c.ReceiverId))
@@ -279,20 +562,232 @@ func writeNewXWithOptionsAndDefaults(buf *jen.File, c Config) {
 }
 
 const (
-	DebugMapFieldTag = "debugmap"
+	DebugMapFieldTag   = "debugmap"
+	ValidationFieldTag = "validate"
+	NestedFieldTag     = "optgen"
 )
 
+// maxNestedDepth bounds how many hops of flattened nested fields writeFlattenLevel will descend
+// into, so a long chain of nested structs can't make generation run away.
+const maxNestedDepth = 3
+
+// validationRule is a single parsed entry from a `validate` struct tag, e.g. `min=1` or `required`.
+type validationRule struct {
+	name string
+	arg  string
+}
+
+// parseValidationTag splits a `validate` struct tag value (e.g. "required,min=1,oneof=a b c") into rules.
+func parseValidationTag(tagValue string) []validationRule {
+	rules := make([]validationRule, 0)
+	for _, part := range strings.Split(tagValue, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if idx := strings.Index(part, "="); idx != -1 {
+			rules = append(rules, validationRule{name: part[:idx], arg: part[idx+1:]})
+			continue
+		}
+		rules = append(rules, validationRule{name: part})
+	}
+	return rules
+}
+
+// hasValidationTags reports whether any field of st carries a `validate` struct tag.
+func hasValidationTags(st *types.Struct) bool {
+	for i := 0; i < st.NumFields(); i++ {
+		tags, err := structtag.Parse(st.Tag(i))
+		if err != nil {
+			continue
+		}
+		if _, err := tags.Get(ValidationFieldTag); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func writeMustNewXWithOptions(buf *jen.File, c Config) {
+	newFuncName := fmt.Sprintf("MustNew%sWithOptions", c.TargetTypeName)
+	baseFuncName := fmt.Sprintf("New%sWithOptions", c.TargetTypeName)
+	buf.Comment(fmt.Sprintf("%s creates a new %s with the passed in options set, panicking if the result fails validation", newFuncName, c.StructName))
+	c.declareTypeParams(buf.Func().Id(newFuncName)).Params(
+		jen.Id("opts").Op("...").Add(c.optTypeCode()),
+	).Op("*").Add(c.StructRef...).BlockFunc(func(grp *jen.Group) {
+		grp.Id(c.ReceiverId).Op(":=").Add(c.instantiate(jen.Id(baseFuncName))).Call(jen.Id("opts").Op("..."))
+		grp.If(
+			jen.Id("err").Op(":=").Id(c.ReceiverId).Dot("Validate").Call(),
+			jen.Id("err").Op("!=").Nil(),
+		).Block(
+			jen.Panic(jen.Id("err")),
+		)
+		grp.Return(jen.Id(c.ReceiverId))
+	})
+}
+
+func writeMustNewXWithOptionsAndDefaults(buf *jen.File, c Config) {
+	newFuncName := fmt.Sprintf("MustNew%sWithOptionsAndDefaults", c.TargetTypeName)
+	baseFuncName := fmt.Sprintf("New%sWithOptionsAndDefaults", c.TargetTypeName)
+	buf.Comment(fmt.Sprintf("%s creates a new %s with the passed in options set starting from the defaults, panicking if the result fails validation", newFuncName, c.StructName))
+	c.declareTypeParams(buf.Func().Id(newFuncName)).Params(
+		jen.Id("opts").Op("...").Add(c.optTypeCode()),
+	).Op("*").Add(c.StructRef...).BlockFunc(func(grp *jen.Group) {
+		grp.Id(c.ReceiverId).Op(":=").Add(c.instantiate(jen.Id(baseFuncName))).Call(jen.Id("opts").Op("..."))
+		grp.If(
+			jen.Id("err").Op(":=").Id(c.ReceiverId).Dot("Validate").Call(),
+			jen.Id("err").Op("!=").Nil(),
+		).Block(
+			jen.Panic(jen.Id("err")),
+		)
+		grp.Return(jen.Id(c.ReceiverId))
+	})
+}
+
+// zeroValueFor returns the jen literal for the zero value of a basic type, used by the `required` rule.
+func zeroValueFor(t types.Type) jen.Code {
+	basic, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return jen.Nil()
+	}
+	switch {
+	case basic.Info()&types.IsString != 0:
+		return jen.Lit("")
+	case basic.Info()&types.IsBoolean != 0:
+		return jen.False()
+	default:
+		return jen.Lit(0)
+	}
+}
+
+// lengthOrValue returns len(fieldSel) for string/slice/array/map fields, and fieldSel itself otherwise,
+// so that `min`/`max` rules compare lengths for collections and values for numerics.
+func lengthOrValue(fieldSel *jen.Statement, t types.Type) jen.Code {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		if u.Info()&types.IsString != 0 {
+			return jen.Len(fieldSel)
+		}
+		return fieldSel
+	case *types.Slice, *types.Array, *types.Map:
+		return jen.Len(fieldSel)
+	default:
+		return fieldSel
+	}
+}
+
+func parseNumberLiteral(arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		panic(fmt.Sprintf("invalid numeric value %q in validate tag", arg))
+	}
+	return n
+}
+
+// writeValidationRule emits the check for a single validation rule on field f, returning a
+// *helpers.ValidationError from the generated Validate method when the rule is not satisfied.
+func writeValidationRule(grp *jen.Group, f *types.Var, rule validationRule, c Config) {
+	fieldSel := jen.Id(c.ReceiverId).Dot(f.Name())
+
+	newValidationError := func(ruleDesc string) jen.Code {
+		return jen.Op("&").Qual("github.com/ecordell/optgen/helpers", "ValidationError").Values(jen.Dict{
+			jen.Id("Field"): jen.Lit(f.Name()),
+			jen.Id("Rule"):  jen.Lit(ruleDesc),
+			jen.Id("Value"): jen.Id(c.ReceiverId).Dot(f.Name()),
+		})
+	}
+
+	switch rule.name {
+	case "required":
+		var cond jen.Code
+		switch f.Type().Underlying().(type) {
+		case *types.Pointer:
+			cond = jen.Add(fieldSel).Op("==").Nil()
+		case *types.Slice, *types.Map, *types.Array:
+			cond = jen.Len(fieldSel).Op("==").Lit(0)
+		case *types.Basic:
+			cond = jen.Add(fieldSel).Op("==").Add(zeroValueFor(f.Type()))
+		default:
+			// structs (e.g. time.Time) have no comparable zero literal via ==; compare structurally
+			zeroRef := typeSpecForType(f.Type(), c)
+			cond = jen.Qual("reflect", "DeepEqual").Call(fieldSel, jen.Add(zeroRef...).Values())
+		}
+		grp.If(cond).Block(
+			jen.Return(newValidationError("required")),
+		)
+
+	case "min", "max":
+		op := "<"
+		if rule.name == "max" {
+			op = ">"
+		}
+		grp.If(jen.Add(lengthOrValue(fieldSel, f.Type())).Op(op).Lit(parseNumberLiteral(rule.arg))).Block(
+			jen.Return(newValidationError(fmt.Sprintf("%s=%s", rule.name, rule.arg))),
+		)
+
+	case "oneof":
+		allowed := strings.Fields(rule.arg)
+		cases := make([]jen.Code, 0, len(allowed))
+		for _, v := range allowed {
+			cases = append(cases, jen.Lit(v))
+		}
+		grp.Switch(fieldSel).Block(
+			jen.Case(cases...),
+			jen.Default().Block(
+				jen.Return(newValidationError(fmt.Sprintf("oneof=%s", rule.arg))),
+			),
+		)
+
+	case "custom":
+		grp.If(
+			jen.List(jen.Id("err")).Op(":=").Id(rule.arg).Call(fieldSel),
+			jen.Id("err").Op("!=").Nil(),
+		).Block(
+			jen.Return(jen.Id("err")),
+		)
+	}
+}
+
+// writeValidate generates a Validate method that enforces every field's `validate` struct tag rules.
+func writeValidate(buf *jen.File, st *types.Struct, c Config) {
+	buf.Comment(fmt.Sprintf("Validate returns an error if %s does not satisfy its `validate` struct tag rules", c.StructName))
+	buf.Func().Params(jen.Id(c.ReceiverId).Add(c.StructRef...)).Id("Validate").Params().Error().BlockFunc(func(grp *jen.Group) {
+		for i := 0; i < st.NumFields(); i++ {
+			f := st.Field(i)
+			if f.Anonymous() {
+				continue
+			}
+
+			tags, err := structtag.Parse(st.Tag(i))
+			if err != nil {
+				panic(err)
+			}
+
+			tag, err := tags.Get(ValidationFieldTag)
+			if err != nil {
+				continue
+			}
+
+			for _, rule := range parseValidationTag(tag.Value()) {
+				writeValidationRule(grp, f, rule, c)
+			}
+		}
+		grp.Return(jen.Nil())
+	})
+}
+
 func writeDebugMap(buf *jen.File, st *types.Struct, c Config, sensitiveNameMatches []string) {
 	newFuncName := fmt.Sprintf("DebugMap")
 
 	buf.Comment(fmt.Sprintf("%s returns a map form of %s for debugging", newFuncName, c.TargetTypeName))
-	buf.Func().Params(jen.Id(c.ReceiverId).Id(c.StructName)).Id(newFuncName).Params().Id("map[string]any").BlockFunc(func(grp *jen.Group) {
+	buf.Func().Params(jen.Id(c.ReceiverId).Add(c.StructRef...)).Id(newFuncName).Params().Id("map[string]any").BlockFunc(func(grp *jen.Group) {
 		mapId := "debugMap"
 		grp.Id(mapId).Op(":=").Map(jen.String()).Any().Values()
 
 		for i := 0; i < st.NumFields(); i++ {
 			f := st.Field(i)
-			if f.Anonymous() || !f.Exported() {
+			// protoc-gen-go's XXX_ bookkeeping fields were never meant to carry a debugmap tag
+			if f.Anonymous() || !f.Exported() || isProtoInternalField(f) {
 				continue
 			}
 
@@ -345,12 +840,242 @@ func writeDebugMap(buf *jen.File, st *types.Struct, c Config, sensitiveNameMatch
 	})
 }
 
+const (
+	EnvFieldTag          = "env"
+	EnvDefaultFieldTag   = "envDefault"
+	EnvSeparatorFieldTag = "envSeparator"
+)
+
+var camelBoundary = regexp.MustCompile("([a-z0-9])([A-Z])")
+
+// screamingSnake converts a CamelCase field name into the SCREAMING_SNAKE_CASE form used for its
+// env var name when no `env` struct tag is present.
+func screamingSnake(name string) string {
+	return strings.ToUpper(camelBoundary.ReplaceAllString(name, "${1}_${2}"))
+}
+
+// isSensitiveField reports whether name matches one of the configured sensitive substrings, the
+// same check writeDebugMap uses to decide whether a field needs to be marked 'sensitive'.
+func isSensitiveField(name string, sensitiveNameMatches []string) bool {
+	lower := strings.ToLower(name)
+	for _, match := range sensitiveNameMatches {
+		if strings.Contains(lower, match) {
+			return true
+		}
+	}
+	return false
+}
+
+// envFieldSettings reads a field's `env`/`envDefault`/`envSeparator` struct tags, falling back to
+// the SCREAMING_SNAKE of its name and a "," separator when unset.
+func envFieldSettings(f *types.Var, rawTag string) (name string, defaultVal string, hasDefault bool, separator string) {
+	name = screamingSnake(f.Name())
+	separator = ","
+
+	tags, _ := structtag.Parse(rawTag)
+	if tags == nil {
+		return name, "", false, separator
+	}
+
+	if tag, err := tags.Get(EnvFieldTag); err == nil && tag.Name != "" {
+		name = tag.Name
+	}
+	if tag, err := tags.Get(EnvDefaultFieldTag); err == nil {
+		defaultVal = tag.Value()
+		hasDefault = true
+	}
+	if tag, err := tags.Get(EnvSeparatorFieldTag); err == nil && tag.Name != "" {
+		separator = tag.Name
+	}
+
+	return name, defaultVal, hasDefault, separator
+}
+
+// basicEnvParser returns the statements that parse the string identifier "raw" into fieldSel for
+// a basic-kinded field, or nil if b's kind isn't one LoadFromEnv/LoadFromMap support.
+func basicEnvParser(fieldSel *jen.Statement, errValue jen.Code, b *types.Basic) func(grp *jen.Group) {
+	switch {
+	case b.Info()&types.IsString != 0:
+		return func(grp *jen.Group) {
+			grp.Add(fieldSel).Op("=").Id("raw")
+		}
+
+	case b.Info()&types.IsBoolean != 0:
+		return func(grp *jen.Group) {
+			grp.List(jen.Id("parsed"), jen.Id("err")).Op(":=").Qual("strconv", "ParseBool").Call(jen.Id("raw"))
+			grp.If(jen.Id("err").Op("!=").Nil()).Block(
+				jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("invalid bool %q: %w"), errValue, jen.Id("err"))),
+			)
+			grp.Add(fieldSel).Op("=").Id("parsed")
+		}
+
+	case b.Info()&types.IsUnsigned != 0:
+		return func(grp *jen.Group) {
+			grp.List(jen.Id("parsed"), jen.Id("err")).Op(":=").Qual("strconv", "ParseUint").Call(jen.Id("raw"), jen.Lit(10), jen.Lit(64))
+			grp.If(jen.Id("err").Op("!=").Nil()).Block(
+				jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("invalid unsigned integer %q: %w"), errValue, jen.Id("err"))),
+			)
+			grp.Add(fieldSel).Op("=").Id(b.Name()).Call(jen.Id("parsed"))
+		}
+
+	case b.Info()&types.IsInteger != 0:
+		return func(grp *jen.Group) {
+			grp.List(jen.Id("parsed"), jen.Id("err")).Op(":=").Qual("strconv", "ParseInt").Call(jen.Id("raw"), jen.Lit(10), jen.Lit(64))
+			grp.If(jen.Id("err").Op("!=").Nil()).Block(
+				jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("invalid integer %q: %w"), errValue, jen.Id("err"))),
+			)
+			grp.Add(fieldSel).Op("=").Id(b.Name()).Call(jen.Id("parsed"))
+		}
+
+	case b.Info()&types.IsFloat != 0:
+		return func(grp *jen.Group) {
+			grp.List(jen.Id("parsed"), jen.Id("err")).Op(":=").Qual("strconv", "ParseFloat").Call(jen.Id("raw"), jen.Lit(64))
+			grp.If(jen.Id("err").Op("!=").Nil()).Block(
+				jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("invalid float %q: %w"), errValue, jen.Id("err"))),
+			)
+			grp.Add(fieldSel).Op("=").Id(b.Name()).Call(jen.Id("parsed"))
+		}
+
+	default:
+		return nil
+	}
+}
+
+// envValueParser returns the statements that parse the string identifier "raw" into fieldSel for
+// t, or ok=false if t is a kind LoadFromEnv/LoadFromMap don't support (basic types, time.Duration,
+// []string, and map[string]string).
+func envValueParser(fieldSel *jen.Statement, errValue jen.Code, t types.Type, separator string) (parse func(grp *jen.Group), ok bool) {
+	if named, isNamed := t.(*types.Named); isNamed && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "time" && named.Obj().Name() == "Duration" {
+		return func(grp *jen.Group) {
+			grp.List(jen.Id("parsed"), jen.Id("err")).Op(":=").Qual("time", "ParseDuration").Call(jen.Id("raw"))
+			grp.If(jen.Id("err").Op("!=").Nil()).Block(
+				jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("invalid duration %q: %w"), errValue, jen.Id("err"))),
+			)
+			grp.Add(fieldSel).Op("=").Id("parsed")
+		}, true
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		parse := basicEnvParser(fieldSel, errValue, u)
+		return parse, parse != nil
+
+	case *types.Slice:
+		elem, isBasic := u.Elem().Underlying().(*types.Basic)
+		if !isBasic || elem.Info()&types.IsString == 0 {
+			return nil, false
+		}
+		return func(grp *jen.Group) {
+			grp.Add(fieldSel).Op("=").Qual("strings", "Split").Call(jen.Id("raw"), jen.Lit(separator))
+		}, true
+
+	case *types.Map:
+		key, keyOk := u.Key().Underlying().(*types.Basic)
+		elem, elemOk := u.Elem().Underlying().(*types.Basic)
+		if !keyOk || !elemOk || key.Info()&types.IsString == 0 || elem.Info()&types.IsString == 0 {
+			return nil, false
+		}
+		return func(grp *jen.Group) {
+			grp.Add(fieldSel).Op("=").Make(jen.Map(jen.String()).String())
+			grp.For(jen.List(jen.Id("_"), jen.Id("pair")).Op(":=").Op("range").Qual("strings", "Split").Call(jen.Id("raw"), jen.Lit(separator))).Block(
+				jen.Id("kv").Op(":=").Qual("strings", "SplitN").Call(jen.Id("pair"), jen.Lit("="), jen.Lit(2)),
+				jen.If(jen.Len(jen.Id("kv")).Op("==").Lit(2)).Block(
+					jen.Add(fieldSel).Index(jen.Id("kv").Index(jen.Lit(0))).Op("=").Id("kv").Index(jen.Lit(1)),
+				),
+			)
+		}, true
+
+	default:
+		return nil, false
+	}
+}
+
+// writeLoadFromEnv generates a LoadFromEnv(prefix string) error method that populates c's fields
+// from environment variables named prefix + each field's `env` tag (or SCREAMING_SNAKE of the
+// field name), honoring `envDefault` and redacting sensitive field values from error messages.
+func writeLoadFromEnv(buf *jen.File, st *types.Struct, c Config, sensitiveNameMatches []string) {
+	funcName := "LoadFromEnv"
+	buf.Comment(fmt.Sprintf("%s populates %s from environment variables named prefix + each field's env name", funcName, c.StructName))
+	buf.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).Id(funcName).Params(jen.Id("prefix").String()).Error().BlockFunc(func(grp *jen.Group) {
+		for i := 0; i < st.NumFields(); i++ {
+			f := st.Field(i)
+			if f.Anonymous() || !f.Exported() {
+				continue
+			}
+
+			name, defaultVal, hasDefault, separator := envFieldSettings(f, st.Tag(i))
+			fieldSel := jen.Id(c.ReceiverId).Dot(f.Name())
+			errValue := jen.Code(jen.Id("raw"))
+			if isSensitiveField(f.Name(), sensitiveNameMatches) {
+				errValue = jen.Lit("[redacted]")
+			}
+
+			parse, ok := envValueParser(fieldSel, errValue, f.Type(), separator)
+			if !ok {
+				continue
+			}
+
+			grp.BlockFunc(func(grp2 *jen.Group) {
+				grp2.Id("key").Op(":=").Id("prefix").Op("+").Lit(name)
+				grp2.List(jen.Id("raw"), jen.Id("ok")).Op(":=").Qual("os", "LookupEnv").Call(jen.Id("key"))
+				if hasDefault {
+					grp2.If(jen.Op("!").Id("ok")).Block(
+						jen.Id("raw").Op("=").Lit(defaultVal),
+						jen.Id("ok").Op("=").True(),
+					)
+				}
+				grp2.If(jen.Id("ok")).BlockFunc(parse)
+			})
+		}
+		grp.Return(jen.Nil())
+	})
+}
+
+// writeLoadFromMap generates a LoadFromMap(m map[string]string) error method that populates c's
+// fields the same way as LoadFromEnv, but reading from m instead of the process environment.
+func writeLoadFromMap(buf *jen.File, st *types.Struct, c Config, sensitiveNameMatches []string) {
+	funcName := "LoadFromMap"
+	buf.Comment(fmt.Sprintf("%s populates %s from values, keyed the same way as LoadFromEnv", funcName, c.StructName))
+	buf.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).Id(funcName).Params(jen.Id("values").Map(jen.String()).String()).Error().BlockFunc(func(grp *jen.Group) {
+		for i := 0; i < st.NumFields(); i++ {
+			f := st.Field(i)
+			if f.Anonymous() || !f.Exported() {
+				continue
+			}
+
+			name, defaultVal, hasDefault, separator := envFieldSettings(f, st.Tag(i))
+			fieldSel := jen.Id(c.ReceiverId).Dot(f.Name())
+			errValue := jen.Code(jen.Id("raw"))
+			if isSensitiveField(f.Name(), sensitiveNameMatches) {
+				errValue = jen.Lit("[redacted]")
+			}
+
+			parse, ok := envValueParser(fieldSel, errValue, f.Type(), separator)
+			if !ok {
+				continue
+			}
+
+			grp.BlockFunc(func(grp2 *jen.Group) {
+				grp2.List(jen.Id("raw"), jen.Id("ok")).Op(":=").Id("values").Index(jen.Lit(name))
+				if hasDefault {
+					grp2.If(jen.Op("!").Id("ok")).Block(
+						jen.Id("raw").Op("=").Lit(defaultVal),
+						jen.Id("ok").Op("=").True(),
+					)
+				}
+				grp2.If(jen.Id("ok")).BlockFunc(parse)
+			})
+		}
+		grp.Return(jen.Nil())
+	})
+}
+
 func writeToOption(buf *jen.File, st *types.Struct, c Config) {
 	newFuncName := fmt.Sprintf("ToOption")
 
 	buf.Comment(fmt.Sprintf("%s returns a new %s that sets the values from the passed in %s", newFuncName, c.OptTypeName, c.StructName))
-	buf.Func().Params(jen.Id(c.ReceiverId).Op("*").Id(c.StructName)).Id(newFuncName).Params().Id(c.OptTypeName).BlockFunc(func(grp *jen.Group) {
-		grp.Return(jen.Func().Params(jen.Id("to").Op("*").Id(c.StructName)).BlockFunc(func(retGrp *jen.Group) {
+	buf.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).Id(newFuncName).Params().Add(c.optTypeCode()).BlockFunc(func(grp *jen.Group) {
+		grp.Return(jen.Func().Params(jen.Id("to").Op("*").Add(c.StructRef...)).BlockFunc(func(retGrp *jen.Group) {
 			for i := 0; i < st.NumFields(); i++ {
 				f := st.Field(i)
 				if f.Anonymous() {
@@ -365,16 +1090,16 @@ func writeToOption(buf *jen.File, st *types.Struct, c Config) {
 func writeXWithOptions(buf *jen.File, c Config) {
 	withFuncName := fmt.Sprintf("%sWithOptions", c.TargetTypeName)
 	buf.Comment(fmt.Sprintf("%s configures an existing %s with the passed in options set", withFuncName, c.StructName))
-	buf.Func().Id(withFuncName).Params(
-		jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...), jen.Id("opts").Op("...").Id(c.OptTypeName),
+	c.declareTypeParams(buf.Func().Id(withFuncName)).Params(
+		jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...), jen.Id("opts").Op("...").Add(c.optTypeCode()),
 	).Op("*").Add(c.StructRef...).BlockFunc(applyOptions(c.ReceiverId))
 }
 
 func writeWithOptions(buf *jen.File, c Config) {
 	withFuncName := "WithOptions"
 	buf.Comment(fmt.Sprintf("%s configures the receiver %s with the passed in options set", withFuncName, c.StructName))
-	buf.Func().Params(jen.Id(c.ReceiverId).Op("*").Id(c.StructName)).Id(withFuncName).
-		Params(jen.Id("opts").Op("...").Id(c.OptTypeName)).Op("*").Add(c.StructRef...).
+	buf.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).Id(withFuncName).
+		Params(jen.Id("opts").Op("...").Add(c.optTypeCode())).Op("*").Add(c.StructRef...).
 		BlockFunc(applyOptions(c.ReceiverId))
 }
 
@@ -387,42 +1112,297 @@ func applyOptions(receiverId string) func(grp *jen.Group) {
 	}
 }
 
-var genericTypeRegex = regexp.MustCompile("[A-Za-z0-9_]+\\.[A-Za-z0-9_]+\\[(.*)\\]")
+func writeAllWithOptFuncs(buf *jen.File, st *types.Struct, outdir string, c Config) {
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if f.Anonymous() {
+			continue
+		}
+
+		// don't write options for unexported fields unless the target is the same package
+		if !f.Exported() && outdir != f.Pkg().Path() {
+			continue
+		}
+
+		// a manifest entry may opt a field out of generation entirely
+		if c.FieldOverrides[f.Name()].Skip {
+			continue
+		}
 
-// genericFromType provides a means to extract the generic type information
-// This returns the type package as first argument, and the unqualified type name as second argument
-// FIXME replace with whatever comes out of https://github.com/golang/go/issues/54393
-func genericFromType(t types.Type) (string, string) {
-	typeName := t.String()
-	match := genericTypeRegex.FindStringSubmatch(typeName)
-	if len(match) == 2 {
-		idx := strings.LastIndex(match[1], ".")
-		name := match[1][idx+1:]
-		packageName := match[1][:idx]
+		if named, ptr, forced, flatten := nestedCandidate(f, st, i); named != nil {
+			if _, ok := nestedOptionType(named); ok || forced {
+				writeNestedWithOpt(buf, f, named, ptr, flatten, c)
+				continue
+			}
+		}
 
-		return packageName, name
+		// build a type specifier based on the field type
+		typeRef := typeSpecForType(f.Type(), c)
+
+		switch f.Type().Underlying().(type) {
+		case *types.Array, *types.Slice:
+			if !c.FieldOverrides[f.Name()].ForceSet {
+				writeSliceWithOpt(buf, f, typeRef, c)
+			}
+			writeSliceSetOpt(buf, f, typeRef, c)
+		case *types.Map:
+			writeMapWithOpt(buf, f, typeRef, c)
+			writeMapSetOpt(buf, f, typeRef, c)
+		default:
+			writeStandardWithOpt(buf, f, typeRef, c)
+		}
 	}
-	return "", ""
 }
 
-func writeAllWithOptFuncs(buf *jen.File, st *types.Struct, outdir string, c Config) {
+// nestedCandidate inspects field f (the i'th field of st) and reports whether it is eligible for
+// recursive nested-option generation: its type (or the type it points to) must be a named struct.
+// forced is set by an opt-in `optgen:"nested"` struct tag; flatten by `optgen:"nested,flatten"`.
+func nestedCandidate(f *types.Var, st *types.Struct, i int) (named *types.Named, ptr bool, forced bool, flatten bool) {
+	t := f.Type()
+	if p, ok := t.(*types.Pointer); ok {
+		ptr = true
+		t = p.Elem()
+	}
+
+	named, ok := t.(*types.Named)
+	if !ok {
+		return nil, false, false, false
+	}
+	if _, ok := named.Underlying().(*types.Struct); !ok {
+		return nil, false, false, false
+	}
+
+	tags, _ := structtag.Parse(st.Tag(i))
+	if tags != nil {
+		if tag, err := tags.Get(NestedFieldTag); err == nil {
+			forced = tag.Name == "nested" || tag.HasOption("nested")
+			flatten = tag.HasOption("flatten") || tag.Name == "flatten"
+		}
+	}
+
+	return named, ptr, forced, flatten
+}
+
+// nestedOptionType looks for a sibling `<Name>Option` functional-option type already generated
+// for named, the convention a prior optgen run would have left behind.
+func nestedOptionType(named *types.Named) (name string, ok bool) {
+	name = fmt.Sprintf("%sOption", named.Obj().Name())
+	return name, named.Obj().Pkg().Scope().Lookup(name) != nil
+}
+
+// writeNestedWithOpt emits With<Field>(opts ...<Nested>Option) for a field whose type is a named
+// struct that itself has generated options, lazily allocating the field on first use when it's a
+// pointer. When flatten is set it additionally emits flattened With<Field><InnerField>(...) forms,
+// via writeFlattenLevel, that reach directly into the nested struct's own fields.
+func writeNestedWithOpt(buf *jen.File, f *types.Var, named *types.Named, ptr bool, flatten bool, c Config) {
+	nestedOptTypeName := fmt.Sprintf("%sOption", named.Obj().Name())
+	nestedRef := typeSpecForType(named, c)
+
+	fieldFuncName := fmt.Sprintf("With%s", strings.Title(optionFieldName(f, c)))
+	accessor := func() *jen.Statement { return jen.Id(c.ReceiverId).Dot(f.Name()) }
+	// optArg is what gets passed to a <Nested>Option, which always expects a pointer: the field
+	// itself when it's already a pointer, or its address when it's a plain value field.
+	optArg := func() *jen.Statement {
+		if ptr {
+			return accessor()
+		}
+		return jen.Op("&").Add(accessor())
+	}
+	allocate := func(grp *jen.Group) {
+		if ptr {
+			grp.If(accessor().Op("==").Nil()).Block(
+				accessor().Op("=").Op("&").Add(nestedRef...).Values(),
+			)
+		}
+	}
+
+	var nestedOptRef *jen.Statement
+	if pkg := named.Obj().Pkg(); pkg == nil || pkg.Path() == c.PkgPath {
+		nestedOptRef = jen.Id(nestedOptTypeName)
+	} else {
+		nestedOptRef = jen.Qual(pkg.Path(), nestedOptTypeName)
+	}
+
+	buf.Comment(fmt.Sprintf("%s returns an option that configures the nested %s.%s via %s options", fieldFuncName, c.StructName, f.Name(), nestedOptTypeName))
+	c.declareTypeParams(buf.Func().Id(fieldFuncName)).Params(
+		jen.Id("opts").Op("...").Add(nestedOptRef),
+	).Add(c.optTypeCode()).BlockFunc(func(grp *jen.Group) {
+		grp.Return(
+			jen.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).BlockFunc(func(grp2 *jen.Group) {
+				allocate(grp2)
+				grp2.For(jen.Id("_").Op(",").Id("o").Op(":=").Op("range").Id("opts")).Block(
+					jen.Id("o").Call(optArg()),
+				)
+			}),
+		)
+	})
+
+	if flatten {
+		visited := map[types.Object]bool{named.Obj(): true}
+		writeFlattenLevel(buf, c, strings.Title(optionFieldName(f, c)), accessor, allocate, named, 1, visited)
+	}
+}
+
+// writeFlattenLevel emits a With<Prefix><InnerField>(...) option, reaching through accessor, for
+// every exported field of named, then recurses into any inner field that is itself a named struct
+// so deeply nested fields get their own flattened setter. Recursion stops at maxNestedDepth or on
+// revisiting a type already on the current path (visited), whichever comes first.
+func writeFlattenLevel(buf *jen.File, c Config, namePrefix string, accessor func() *jen.Statement, allocate func(grp *jen.Group), named *types.Named, depth int, visited map[types.Object]bool) {
+	if depth > maxNestedDepth {
+		return
+	}
+
+	nst, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		return
+	}
+
+	for i := 0; i < nst.NumFields(); i++ {
+		inner := nst.Field(i)
+		if inner.Anonymous() || !inner.Exported() {
+			continue
+		}
+
+		innerType := inner.Type()
+		innerPtr := false
+		if p, ok := innerType.(*types.Pointer); ok {
+			innerPtr = true
+			innerType = p.Elem()
+		}
+
+		fieldFuncName := fmt.Sprintf("With%s%s", namePrefix, strings.Title(inner.Name()))
+		innerRef := typeSpecForType(inner.Type(), c)
+
+		buf.Comment(fmt.Sprintf("%s reaches into %s's nested %s field and sets %s directly", fieldFuncName, c.StructName, namePrefix, inner.Name()))
+		c.declareTypeParams(buf.Func().Id(fieldFuncName)).Params(
+			jen.Id(unexport(inner.Name())).Add(innerRef...),
+		).Add(c.optTypeCode()).BlockFunc(func(grp *jen.Group) {
+			grp.Return(
+				jen.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).BlockFunc(func(grp2 *jen.Group) {
+					allocate(grp2)
+					grp2.Add(accessor()).Dot(inner.Name()).Op("=").Id(unexport(inner.Name()))
+				}),
+			)
+		})
+
+		innerNamed, ok := innerType.(*types.Named)
+		if !ok || visited[innerNamed.Obj()] {
+			continue
+		}
+		if _, ok := innerNamed.Underlying().(*types.Struct); !ok {
+			continue
+		}
+
+		childAccessor := func() *jen.Statement { return accessor().Dot(inner.Name()) }
+		childAllocate := func(grp *jen.Group) {
+			allocate(grp)
+			if innerPtr {
+				grp.If(accessor().Dot(inner.Name()).Op("==").Nil()).Block(
+					accessor().Dot(inner.Name()).Op("=").Op("&").Add(typeSpecForType(innerNamed, c)...).Values(),
+				)
+			}
+		}
+
+		visited[innerNamed.Obj()] = true
+		writeFlattenLevel(buf, c, namePrefix+strings.Title(inner.Name()), childAccessor, childAllocate, innerNamed, depth+1, visited)
+		delete(visited, innerNamed.Obj())
+	}
+}
+
+// isProtoInternalField reports whether f is one of the XXX_ bookkeeping fields protoc-gen-go adds
+// to every generated message (XXX_NoUnkeyedLiteral, XXX_unrecognized, XXX_sizecache, ...).
+func isProtoInternalField(f *types.Var) bool {
+	return strings.HasPrefix(f.Name(), "XXX_")
+}
+
+// oneofWrapperTypes finds every named struct in pkg whose (pointer) method set implements iface,
+// i.e. the generated wrapper types protoc-gen-go emits for each case of a oneof field.
+func oneofWrapperTypes(pkg *types.Package, iface *types.Interface) []*types.Named {
+	wrappers := make([]*types.Named, 0)
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		named, ok := tn.Type().(*types.Named)
+		if !ok {
+			continue
+		}
+		if _, ok := named.Underlying().(*types.Struct); !ok {
+			continue
+		}
+		if types.Implements(named, iface) || types.Implements(types.NewPointer(named), iface) {
+			wrappers = append(wrappers, named)
+		}
+	}
+	return wrappers
+}
+
+// writeOneofWithOpts generates a WithFooKind<Case>(...) option for every wrapper type implementing
+// a oneof field's interface, each of which allocates the correct wrapper and assigns it to the field.
+func writeOneofWithOpts(buf *jen.File, f *types.Var, iface *types.Interface, c Config) {
+	for _, wrapper := range oneofWrapperTypes(f.Pkg(), iface) {
+		wst, ok := wrapper.Underlying().(*types.Struct)
+		if !ok || wst.NumFields() != 1 {
+			continue
+		}
+		inner := wst.Field(0)
+		kindName := strings.TrimPrefix(wrapper.Obj().Name(), c.StructName+"_")
+		fieldFuncName := fmt.Sprintf("With%sKind%s", strings.Title(f.Name()), strings.Title(kindName))
+		innerRef := typeSpecForType(inner.Type(), c)
+
+		buf.Comment(fmt.Sprintf("%s returns an option that sets %s.%s to the %s oneof case", fieldFuncName, c.StructName, f.Name(), kindName))
+		var wrapperRef *jen.Statement
+		if pkg := wrapper.Obj().Pkg(); pkg == nil || pkg.Path() == c.PkgPath {
+			wrapperRef = jen.Id(wrapper.Obj().Name())
+		} else {
+			wrapperRef = jen.Qual(pkg.Path(), wrapper.Obj().Name())
+		}
+
+		c.declareTypeParams(buf.Func().Id(fieldFuncName)).Params(
+			jen.Id(unexport(inner.Name())).Add(innerRef...),
+		).Add(c.optTypeCode()).BlockFunc(func(grp *jen.Group) {
+			grp.Return(
+				jen.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).BlockFunc(func(grp2 *jen.Group) {
+					grp2.Id(c.ReceiverId).Op(".").Id(f.Name()).Op("=").Op("&").Add(wrapperRef).Values(jen.Dict{
+						jen.Id(inner.Name()): jen.Id(unexport(inner.Name())),
+					})
+				}),
+			)
+		})
+	}
+}
+
+// writeAllProtoWithOptFuncs is writeAllWithOptFuncs's counterpart for proto messages: it skips the
+// XXX_ bookkeeping fields and generates oneof-case setters, via writeOneofWithOpts, for oneof fields.
+func writeAllProtoWithOptFuncs(buf *jen.File, st *types.Struct, outdir string, c Config) {
 	for i := 0; i < st.NumFields(); i++ {
 		f := st.Field(i)
-		if f.Anonymous() {
+		if f.Anonymous() || isProtoInternalField(f) {
 			continue
 		}
 
-		// don't write options for unexported fields unless the target is the same package
 		if !f.Exported() && outdir != f.Pkg().Path() {
 			continue
 		}
 
-		// build a type specifier based on the field type
+		if c.FieldOverrides[f.Name()].Skip {
+			continue
+		}
+
+		if iface, ok := f.Type().Underlying().(*types.Interface); ok {
+			writeOneofWithOpts(buf, f, iface, c)
+			continue
+		}
+
 		typeRef := typeSpecForType(f.Type(), c)
 
 		switch f.Type().Underlying().(type) {
 		case *types.Array, *types.Slice:
-			writeSliceWithOpt(buf, f, typeRef, c)
+			if !c.FieldOverrides[f.Name()].ForceSet {
+				writeSliceWithOpt(buf, f, typeRef, c)
+			}
 			writeSliceSetOpt(buf, f, typeRef, c)
 		case *types.Map:
 			writeMapWithOpt(buf, f, typeRef, c)
@@ -433,18 +1413,60 @@ func writeAllWithOptFuncs(buf *jen.File, st *types.Struct, outdir string, c Conf
 	}
 }
 
-func writeSliceWithOpt(buf *jen.File, f *types.Var, ref []jen.Code, c Config) {
-	genericPackage, genericName := genericFromType(f.Type())
+// writeFromProto generates a FromProto function that reconstructs the options needed to recreate
+// every concrete (non-oneof) field set on a proto message m.
+func writeFromProto(buf *jen.File, st *types.Struct, c Config) {
+	funcName := "FromProto"
+	buf.Comment(fmt.Sprintf("%s returns the %s needed to recreate the fields set on m", funcName, c.OptTypeName))
+	buf.Func().Id(funcName).Params(
+		jen.Id("m").Op("*").Add(c.StructRef...),
+	).Index().Add(c.optTypeCode()).BlockFunc(func(grp *jen.Group) {
+		grp.Id("opts").Op(":=").Make(jen.Index().Add(c.optTypeCode()), jen.Lit(0), jen.Lit(st.NumFields()))
+		for i := 0; i < st.NumFields(); i++ {
+			f := st.Field(i)
+			if f.Anonymous() || isProtoInternalField(f) {
+				continue
+			}
+			if _, ok := f.Type().Underlying().(*types.Interface); ok {
+				continue
+			}
+
+			fieldFuncName := fmt.Sprintf("With%s", strings.Title(f.Name()))
+			switch f.Type().Underlying().(type) {
+			case *types.Array, *types.Slice, *types.Map:
+				fieldFuncName = fmt.Sprintf("Set%s", strings.Title(f.Name()))
+			}
+			grp.Id("opts").Op("=").Append(jen.Id("opts"), jen.Id(fieldFuncName).Call(jen.Id("m").Dot(f.Name())))
+		}
+		grp.Return(jen.Id("opts"))
+	})
+}
+
+// writeApplyToProto generates an ApplyToProto method that copies every concrete field onto m,
+// leaving oneof fields, which can't be split back into independent options, untouched.
+func writeApplyToProto(buf *jen.File, st *types.Struct, c Config) {
+	funcName := "ApplyToProto"
+	buf.Comment(fmt.Sprintf("%s copies every field of %s onto the protobuf message m", funcName, c.StructName))
+	buf.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).Id(funcName).Params(
+		jen.Id("m").Op("*").Add(c.StructRef...),
+	).BlockFunc(func(grp *jen.Group) {
+		for i := 0; i < st.NumFields(); i++ {
+			f := st.Field(i)
+			if f.Anonymous() || isProtoInternalField(f) {
+				continue
+			}
+			grp.Id("m").Dot(f.Name()).Op("=").Id(c.ReceiverId).Dot(f.Name())
+		}
+	})
+}
 
+func writeSliceWithOpt(buf *jen.File, f *types.Var, ref []jen.Code, c Config) {
 	ref = ref[1:] // remove the first element, which should be [] for slice types
-	fieldFuncName := fmt.Sprintf("With%s", strings.Title(f.Name()))
+	fieldFuncName := fmt.Sprintf("With%s", strings.Title(optionFieldName(f, c)))
 	buf.Comment(fmt.Sprintf("%s returns an option that can append %ss to %s.%s", fieldFuncName, strings.Title(f.Name()), c.StructName, f.Name()))
 	arg := jen.Id(unexport(f.Name())).Add(ref...)
-	if genericName != "" {
-		arg = arg.Types(jen.Qual(genericPackage, genericName))
-	}
 
-	buf.Func().Id(fieldFuncName).Params(arg).Id(c.OptTypeName).BlockFunc(func(grp *jen.Group) {
+	c.declareTypeParams(buf.Func().Id(fieldFuncName)).Params(arg).Add(c.optTypeCode()).BlockFunc(func(grp *jen.Group) {
 		grp.Return(
 			jen.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).BlockFunc(func(grp2 *jen.Group) {
 				grp2.Id(c.ReceiverId).Op(".").Id(f.Name()).Op("=").Append(jen.Id(c.ReceiverId).Op(".").Id(f.Name()), jen.Id(unexport(f.Name())))
@@ -454,16 +1476,11 @@ func writeSliceWithOpt(buf *jen.File, f *types.Var, ref []jen.Code, c Config) {
 }
 
 func writeSliceSetOpt(buf *jen.File, f *types.Var, ref []jen.Code, c Config) {
-	genericPackage, genericName := genericFromType(f.Type())
-
-	fieldFuncName := fmt.Sprintf("Set%s", strings.Title(f.Name()))
+	fieldFuncName := fmt.Sprintf("Set%s", strings.Title(optionFieldName(f, c)))
 	buf.Comment(fmt.Sprintf("%s returns an option that can set %s on a %s", fieldFuncName, strings.Title(f.Name()), c.StructName))
 
 	param := jen.Id(unexport(f.Name())).Add(ref...)
-	if genericName != "" {
-		param = param.Types(jen.Qual(genericPackage, genericName))
-	}
-	buf.Func().Id(fieldFuncName).Params(param).Id(c.OptTypeName).BlockFunc(func(grp *jen.Group) {
+	c.declareTypeParams(buf.Func().Id(fieldFuncName)).Params(param).Add(c.optTypeCode()).BlockFunc(func(grp *jen.Group) {
 		grp.Return(
 			jen.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).BlockFunc(func(grp2 *jen.Group) {
 				grp2.Id(c.ReceiverId).Op(".").Id(f.Name()).Op("=").Id(unexport(f.Name()))
@@ -482,12 +1499,12 @@ func writeMapWithOpt(buf *jen.File, f *types.Var, ref []jen.Code, c Config) {
 		}
 	}
 	m := mapType.(*types.Map)
-	fieldFuncName := fmt.Sprintf("With%s", strings.Title(f.Name()))
+	fieldFuncName := fmt.Sprintf("With%s", strings.Title(optionFieldName(f, c)))
 	buf.Comment(fmt.Sprintf("%s returns an option that can append %ss to %s.%s", fieldFuncName, strings.Title(f.Name()), c.StructName, f.Name()))
-	buf.Func().Id(fieldFuncName).Params(
-		jen.Id("key").Id(m.Key().String()),
-		jen.Id("value").Id(m.Elem().String()),
-	).Id(c.OptTypeName).BlockFunc(func(grp *jen.Group) {
+	c.declareTypeParams(buf.Func().Id(fieldFuncName)).Params(
+		jen.Id("key").Add(typeSpecForType(m.Key(), c)...),
+		jen.Id("value").Add(typeSpecForType(m.Elem(), c)...),
+	).Add(c.optTypeCode()).BlockFunc(func(grp *jen.Group) {
 		grp.Return(
 			jen.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).BlockFunc(func(grp2 *jen.Group) {
 				grp2.Id(c.ReceiverId).Op(".").Id(f.Name()).Index(jen.Id("key")).Op("=").Id("value")
@@ -497,11 +1514,11 @@ func writeMapWithOpt(buf *jen.File, f *types.Var, ref []jen.Code, c Config) {
 }
 
 func writeMapSetOpt(buf *jen.File, f *types.Var, ref []jen.Code, c Config) {
-	fieldFuncName := fmt.Sprintf("Set%s", strings.Title(f.Name()))
+	fieldFuncName := fmt.Sprintf("Set%s", strings.Title(optionFieldName(f, c)))
 	buf.Comment(fmt.Sprintf("%s returns an option that can set %s on a %s", fieldFuncName, strings.Title(f.Name()), c.StructName))
-	buf.Func().Id(fieldFuncName).Params(
+	c.declareTypeParams(buf.Func().Id(fieldFuncName)).Params(
 		jen.Id(unexport(f.Name())).Add(ref...),
-	).Id(c.OptTypeName).BlockFunc(func(grp *jen.Group) {
+	).Add(c.optTypeCode()).BlockFunc(func(grp *jen.Group) {
 		grp.Return(
 			jen.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).BlockFunc(func(grp2 *jen.Group) {
 				grp2.Id(c.ReceiverId).Op(".").Id(f.Name()).Op("=").Id(unexport(f.Name()))
@@ -511,11 +1528,11 @@ func writeMapSetOpt(buf *jen.File, f *types.Var, ref []jen.Code, c Config) {
 }
 
 func writeStandardWithOpt(buf *jen.File, f *types.Var, ref []jen.Code, c Config) {
-	fieldFuncName := fmt.Sprintf("With%s", strings.Title(f.Name()))
+	fieldFuncName := fmt.Sprintf("With%s", strings.Title(optionFieldName(f, c)))
 	buf.Comment(fmt.Sprintf("%s returns an option that can set %s on a %s", fieldFuncName, strings.Title(f.Name()), c.StructName))
-	buf.Func().Id(fieldFuncName).Params(
+	c.declareTypeParams(buf.Func().Id(fieldFuncName)).Params(
 		jen.Id(unexport(f.Name())).Add(ref...),
-	).Id(c.OptTypeName).BlockFunc(func(grp *jen.Group) {
+	).Add(c.optTypeCode()).BlockFunc(func(grp *jen.Group) {
 		grp.Return(
 			jen.Func().Params(jen.Id(c.ReceiverId).Op("*").Add(c.StructRef...)).BlockFunc(func(grp2 *jen.Group) {
 				grp2.Id(c.ReceiverId).Op(".").Id(f.Name()).Op("=").Id(unexport(f.Name()))
@@ -542,11 +1559,36 @@ func typeSpecForType(in types.Type, c Config) (ref []jen.Code) {
 			ref = append(ref, jen.Op("*"))
 			current = t.Elem()
 		case *types.Named:
-			if t.Obj().Pkg().Path() == c.PkgPath {
-				ref = append(ref, jen.Id(t.Obj().Name()))
+			var named *jen.Statement
+			// predeclared/universe named types (e.g. `error`, `comparable`) have no package
+			if pkg := t.Obj().Pkg(); pkg == nil || pkg.Path() == c.PkgPath {
+				named = jen.Id(t.Obj().Name())
 			} else {
-				ref = append(ref, jen.Qual(t.Obj().Pkg().Path(), t.Obj().Name()))
+				named = jen.Qual(pkg.Path(), t.Obj().Name())
 			}
+			if targs := t.TypeArgs(); targs != nil && targs.Len() > 0 {
+				argRefs := make([]jen.Code, 0, targs.Len())
+				for i := 0; i < targs.Len(); i++ {
+					argRefs = append(argRefs, typeSpecForType(targs.At(i), c)...)
+				}
+				named = named.Types(argRefs...)
+			}
+			ref = append(ref, named)
+			return
+		case *types.TypeParam:
+			ref = append(ref, jen.Id(t.Obj().Name()))
+			return
+		case *types.Interface:
+			if t.Empty() {
+				ref = append(ref, jen.Any())
+			} else {
+				ref = append(ref, jen.Interface())
+			}
+			return
+		case *types.Union:
+			// best-effort: constraint unions (e.g. `int | int64`) aren't expressible via jennifer's
+			// type builders, so fall back to the literal source text of the constraint.
+			ref = append(ref, jen.Id(t.String()))
 			return
 		case *types.Basic:
 			ref = append(ref, jen.Id(t.Name()))
@@ -555,7 +1597,9 @@ func typeSpecForType(in types.Type, c Config) (ref []jen.Code) {
 			ref = append(ref, jen.Struct())
 			return
 		case *types.Map:
-			ref = append(ref, jen.Map(jen.Id(t.Key().String())).Id(t.Elem().String()))
+			keyRef := typeSpecForType(t.Key(), c)
+			elemRef := typeSpecForType(t.Elem(), c)
+			ref = append(ref, jen.Map(jen.Add(keyRef...)).Add(elemRef...))
 			return
 		default:
 			if depth > 10 {
@@ -0,0 +1,23 @@
+// Package fixture provides small structs exercised by TestGenerateForFileProducesCompilableCode
+// in ../../main_test.go: a plain struct covering validate/env/debugmap/slice/map fields, and a
+// generic struct covering the type-parameter plumbing added for generics support.
+package fixture
+
+// Simple is a representative target for option generation: basic fields plus validate, env, and
+// debugmap tags.
+type Simple struct {
+	Name        string            `validate:"required" debugmap:"hidden"`
+	Count       int               `validate:"min=1" debugmap:"hidden"`
+	Tags        []string          `debugmap:"hidden"`
+	Meta        map[string]string `debugmap:"hidden"`
+	SecureToken string            `debugmap:"hidden"`
+	Host        string            `env:"HOST" envDefault:"localhost" debugmap:"hidden"`
+	AllowList   []string          `env:"ALLOW_LIST" envDefault:"a,b,c" envSeparator:";" debugmap:"hidden"`
+}
+
+// Box is a generic target, regression coverage for generated code that must reference its own
+// type parameter consistently across the Option type, constructors, and field setters.
+type Box[T any] struct {
+	Value T      `debugmap:"hidden"`
+	Label string `debugmap:"hidden"`
+}